@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// CacheBackend 抽象快取的儲存方式，讓 WeatherService 可以在
+// 一般字串快取與 RedisJSON 結構化快取之間切換。
+type CacheBackend interface {
+	// Get 回傳整份快取內容（未解析），以及是否命中。
+	Get(ctx context.Context, key string) (string, bool, error)
+	// Set 將完整的天氣資料寫入快取。
+	Set(ctx context.Context, key string, value interface{}, ttlSeconds int) error
+	// GetFields 只取出指定欄位，fields 為點記法路徑（例如 "conditions.days[0].temp"）。
+	// 回傳 nil, false 代表整把 key 都沒命中，呼叫端應該走一般的 cache miss 流程。
+	GetFields(ctx context.Context, key string, fields []string) (map[string]interface{}, bool, error)
+}
+
+// StringCache 是既有行為：整份回應以 JSON 字串存在單一 key 底下。
+type StringCache struct {
+	redisClient *redis.Client
+}
+
+func NewStringCache(redisClient *redis.Client) *StringCache {
+	return &StringCache{redisClient: redisClient}
+}
+
+func (c *StringCache) Get(ctx context.Context, key string) (string, bool, error) {
+	ctx, span := startSpan(ctx, "redis.Get")
+	defer span.End()
+	start := time.Now()
+	val, err := c.redisClient.Get(ctx, key).Result()
+	observeRedisLatency("Get", start)
+
+	if err == redis.Nil {
+		cacheMissesTotal.WithLabelValues("string").Inc()
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	cacheHitsTotal.WithLabelValues("string").Inc()
+	return val, true, nil
+}
+
+func (c *StringCache) Set(ctx context.Context, key string, value interface{}, ttlSeconds int) error {
+	ctx, span := startSpan(ctx, "redis.SetEx")
+	defer span.End()
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = c.redisClient.SetEx(ctx, key, string(raw), secondsToDuration(ttlSeconds)).Err()
+	observeRedisLatency("SetEx", start)
+	return err
+}
+
+// GetFields 對字串快取來說沒有欄位級別的投影能力，因此整份取出後
+// 在應用端自行挑選欄位。
+func (c *StringCache) GetFields(ctx context.Context, key string, fields []string) (map[string]interface{}, bool, error) {
+	raw, hit, err := c.Get(ctx, key)
+	if err != nil || !hit {
+		return nil, hit, err
+	}
+	var full map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &full); err != nil {
+		return nil, false, err
+	}
+	return projectFields(full, fields), true, nil
+}
+
+// ReJSONCache 使用 RedisJSON 模組（JSON.SET / JSON.GET）將天氣資料存成
+// 原生 JSON 文件，讓我們可以用 JSONPath 只取出需要的欄位，
+// 避免每次都要傳輸整份文件。
+type ReJSONCache struct {
+	redisClient *redis.Client
+}
+
+func NewReJSONCache(redisClient *redis.Client) *ReJSONCache {
+	return &ReJSONCache{redisClient: redisClient}
+}
+
+func (c *ReJSONCache) Get(ctx context.Context, key string) (string, bool, error) {
+	ctx, span := startSpan(ctx, "redis.Get")
+	defer span.End()
+	start := time.Now()
+	res, err := c.redisClient.Do(ctx, "JSON.GET", key, ".").Result()
+	observeRedisLatency("JSON.GET", start)
+
+	if err == redis.Nil {
+		cacheMissesTotal.WithLabelValues("rejson").Inc()
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	str, ok := res.(string)
+	if !ok {
+		return "", false, fmt.Errorf("unexpected JSON.GET reply type %T", res)
+	}
+	cacheHitsTotal.WithLabelValues("rejson").Inc()
+	return str, true, nil
+}
+
+func (c *ReJSONCache) Set(ctx context.Context, key string, value interface{}, ttlSeconds int) error {
+	ctx, span := startSpan(ctx, "redis.SetEx")
+	defer span.End()
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = c.redisClient.Do(ctx, "JSON.SET", key, ".", string(raw)).Err()
+	observeRedisLatency("JSON.SET", start)
+	if err != nil {
+		return err
+	}
+	return c.redisClient.Expire(ctx, key, secondsToDuration(ttlSeconds)).Err()
+}
+
+// GetFields 把點記法欄位（temp、humidity、conditions.days[0]）轉成
+// JSONPath（$.temp、$.humidity、$.conditions.days[0]），並用單一
+// JSON.GET 指令一次取回所有欄位。
+func (c *ReJSONCache) GetFields(ctx context.Context, key string, fields []string) (map[string]interface{}, bool, error) {
+	ctx, span := startSpan(ctx, "redis.Get")
+	defer span.End()
+
+	args := make([]interface{}, 0, len(fields)+2)
+	args = append(args, "JSON.GET", key)
+	paths := make([]string, 0, len(fields))
+	for _, f := range fields {
+		path := fieldToJSONPath(f)
+		paths = append(paths, path)
+		args = append(args, path)
+	}
+
+	start := time.Now()
+	res, err := c.redisClient.Do(ctx, args...).Result()
+	observeRedisLatency("JSON.GET", start)
+
+	if err == redis.Nil {
+		cacheMissesTotal.WithLabelValues("rejson").Inc()
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	str, ok := res.(string)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected JSON.GET reply type %T", res)
+	}
+
+	// 只有一個路徑時 RedisJSON 回傳單一值；多個路徑時回傳
+	// 「路徑 -> 值陣列」的物件，統一攤平成 field -> value。
+	var projected map[string]interface{}
+	if len(paths) == 1 {
+		var v interface{}
+		if err := json.Unmarshal([]byte(str), &v); err != nil {
+			return nil, false, err
+		}
+		projected = map[string]interface{}{fields[0]: v}
+		cacheHitsTotal.WithLabelValues("rejson").Inc()
+		return projected, true, nil
+	}
+
+	var byPath map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(str), &byPath); err != nil {
+		return nil, false, err
+	}
+	projected = make(map[string]interface{}, len(fields))
+	for i, f := range fields {
+		raw, ok := byPath[paths[i]]
+		if !ok {
+			continue
+		}
+		var values []interface{}
+		if err := json.Unmarshal(raw, &values); err == nil && len(values) == 1 {
+			projected[f] = values[0]
+		} else {
+			var single interface{}
+			json.Unmarshal(raw, &single)
+			projected[f] = single
+		}
+	}
+	cacheHitsTotal.WithLabelValues("rejson").Inc()
+	return projected, true, nil
+}
+
+// fieldToJSONPath 將 "conditions.days[0].temp" 這類點記法欄位轉成
+// RedisJSON 認得的 JSONPath "$.conditions.days[0].temp"。
+func fieldToJSONPath(field string) string {
+	return "$." + strings.TrimPrefix(field, ".")
+}
+
+// projectFields 從完整的天氣資料中挑出點記法路徑指定的欄位，
+// 支援簡單的陣列索引，例如 "days[0].temp"。
+func projectFields(full map[string]interface{}, fields []string) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		if v, ok := lookupDotPath(full, f); ok {
+			out[f] = v
+		}
+	}
+	return out
+}
+
+func lookupDotPath(root map[string]interface{}, path string) (interface{}, bool) {
+	var cur interface{} = root
+	for _, part := range strings.Split(path, ".") {
+		name := part
+		index := -1
+		if i := strings.IndexByte(part, '['); i >= 0 && strings.HasSuffix(part, "]") {
+			name = part[:i]
+			fmt.Sscanf(part[i+1:len(part)-1], "%d", &index)
+		}
+
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		next, ok := m[name]
+		if !ok {
+			return nil, false
+		}
+		cur = next
+
+		if index >= 0 {
+			arr, ok := cur.([]interface{})
+			if !ok || index >= len(arr) {
+				return nil, false
+			}
+			cur = arr[index]
+		}
+	}
+	return cur, true
+}
+
+// NewCacheBackend 依 CACHE_BACKEND 環境變數選擇快取實作，
+// 預設維持原本的字串快取行為。
+func NewCacheBackend(backend string, redisClient *redis.Client) CacheBackend {
+	if strings.EqualFold(backend, "rejson") {
+		return NewReJSONCache(redisClient)
+	}
+	return NewStringCache(redisClient)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedis 啟動一個 miniredis 執行個體供測試使用，並額外掛上
+// 極簡的 JSON.SET / JSON.GET 處理常式，模擬 ReJSONCache 需要的
+// RedisJSON 行為（miniredis 本身不支援 RedisJSON 模組）。
+func newTestRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	registerFakeReJSON(t, mr)
+	return redis.NewClient(&redis.Options{Addr: mr.Addr()})
+}
+
+// registerFakeReJSON 用一個簡單的 map 模擬 RedisJSON 的文件儲存，
+// 只支援本檔測試會用到的 "." 與 "$.field" 路徑語法。
+func registerFakeReJSON(t *testing.T, mr *miniredis.Miniredis) {
+	t.Helper()
+	store := map[string]string{}
+
+	if err := mr.Server().Register("JSON.SET", func(c *server.Peer, cmd string, args []string) {
+		if len(args) != 3 {
+			c.WriteError("ERR wrong number of arguments for 'json.set' command")
+			return
+		}
+		store[args[0]] = args[2]
+		c.WriteOK()
+	}); err != nil {
+		t.Fatalf("register JSON.SET: %v", err)
+	}
+
+	if err := mr.Server().Register("JSON.GET", func(c *server.Peer, cmd string, args []string) {
+		if len(args) < 2 {
+			c.WriteError("ERR wrong number of arguments for 'json.get' command")
+			return
+		}
+		raw, ok := store[args[0]]
+		if !ok {
+			c.WriteNull()
+			return
+		}
+		paths := args[1:]
+		if len(paths) == 1 && paths[0] == "." {
+			c.WriteBulk(raw)
+			return
+		}
+
+		var doc map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+			c.WriteError("ERR invalid document")
+			return
+		}
+
+		if len(paths) == 1 {
+			value, ok := lookupJSONPath(doc, paths[0])
+			if !ok {
+				c.WriteNull()
+				return
+			}
+			encoded, _ := json.Marshal(value)
+			c.WriteBulk(string(encoded))
+			return
+		}
+
+		out := make(map[string]interface{}, len(paths))
+		for _, p := range paths {
+			if value, ok := lookupJSONPath(doc, p); ok {
+				out[p] = []interface{}{value}
+			}
+		}
+		encoded, _ := json.Marshal(out)
+		c.WriteBulk(string(encoded))
+	}); err != nil {
+		t.Fatalf("register JSON.GET: %v", err)
+	}
+}
+
+// lookupJSONPath 把 "$.current.temp" 這種 JSONPath 轉回點記法後，
+// 交給 cache.go 已有的 lookupDotPath 解析。
+func lookupJSONPath(doc map[string]interface{}, path string) (interface{}, bool) {
+	return lookupDotPath(doc, strings.TrimPrefix(path, "$."))
+}
+
+func testForecast() *Forecast {
+	return &Forecast{
+		City:    "Lagos",
+		Coords:  Coordinates{Lat: 6.45, Lon: 3.39},
+		Current: CurrentConditions{Temp: 30.5, Humidity: 80, Conditions: "Clear"},
+		Daily: []DailyForecast{
+			{Date: "2024-01-01", TempHigh: 32, TempLow: 24, Conditions: "Sunny"},
+		},
+	}
+}
+
+func TestStringCache_GetSetRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	cache := NewStringCache(newTestRedis(t))
+
+	if err := cache.Set(ctx, "weather:v2:lagos", testForecast(), 60); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	raw, hit, err := cache.Get(ctx, "weather:v2:lagos")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !hit {
+		t.Fatal("expected cache hit")
+	}
+
+	var got Forecast
+	if err := json.Unmarshal([]byte(raw), &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got.City != "Lagos" || got.Current.Temp != 30.5 {
+		t.Fatalf("unexpected forecast: %+v", got)
+	}
+}
+
+func TestStringCache_Get_Miss(t *testing.T) {
+	ctx := context.Background()
+	cache := NewStringCache(newTestRedis(t))
+
+	_, hit, err := cache.Get(ctx, "weather:v2:nowhere")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if hit {
+		t.Fatal("expected cache miss")
+	}
+}
+
+func TestStringCache_GetFields(t *testing.T) {
+	ctx := context.Background()
+	cache := NewStringCache(newTestRedis(t))
+	if err := cache.Set(ctx, "weather:v2:lagos", testForecast(), 60); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	fields, hit, err := cache.GetFields(ctx, "weather:v2:lagos", []string{"current.temp", "daily[0].tempHigh"})
+	if err != nil {
+		t.Fatalf("GetFields: %v", err)
+	}
+	if !hit {
+		t.Fatal("expected cache hit")
+	}
+	if fields["current.temp"] != 30.5 {
+		t.Fatalf("current.temp = %v, want 30.5", fields["current.temp"])
+	}
+	if fields["daily[0].tempHigh"] != float64(32) {
+		t.Fatalf("daily[0].tempHigh = %v, want 32", fields["daily[0].tempHigh"])
+	}
+}
+
+func TestReJSONCache_GetSetRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	cache := NewReJSONCache(newTestRedis(t))
+
+	if err := cache.Set(ctx, "weather:v2:lagos", testForecast(), 60); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	raw, hit, err := cache.Get(ctx, "weather:v2:lagos")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !hit {
+		t.Fatal("expected cache hit")
+	}
+
+	var got Forecast
+	if err := json.Unmarshal([]byte(raw), &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got.City != "Lagos" {
+		t.Fatalf("unexpected forecast: %+v", got)
+	}
+}
+
+// TestReJSONCache_GetFields_SinglePath 涵蓋只有一個欄位時 RedisJSON
+// 回傳「單一值」而非包在陣列/物件裡的情況（cache.go 裡 GetFields 的
+// 兩種回覆格式分支之一）。
+func TestReJSONCache_GetFields_SinglePath(t *testing.T) {
+	ctx := context.Background()
+	cache := NewReJSONCache(newTestRedis(t))
+	if err := cache.Set(ctx, "weather:v2:lagos", testForecast(), 60); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	fields, hit, err := cache.GetFields(ctx, "weather:v2:lagos", []string{"current.temp"})
+	if err != nil {
+		t.Fatalf("GetFields: %v", err)
+	}
+	if !hit {
+		t.Fatal("expected cache hit")
+	}
+	if fields["current.temp"] != 30.5 {
+		t.Fatalf("current.temp = %v, want 30.5", fields["current.temp"])
+	}
+}
+
+// TestReJSONCache_GetFields_MultiPath 涵蓋多個欄位時 RedisJSON 回傳
+// 「路徑 -> 值陣列」物件的情況，確認攤平邏輯有把每個路徑對應回正確欄位。
+func TestReJSONCache_GetFields_MultiPath(t *testing.T) {
+	ctx := context.Background()
+	cache := NewReJSONCache(newTestRedis(t))
+	if err := cache.Set(ctx, "weather:v2:lagos", testForecast(), 60); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	fields, hit, err := cache.GetFields(ctx, "weather:v2:lagos", []string{"current.temp", "current.conditions", "daily[0].tempHigh"})
+	if err != nil {
+		t.Fatalf("GetFields: %v", err)
+	}
+	if !hit {
+		t.Fatal("expected cache hit")
+	}
+	if fields["current.temp"] != 30.5 {
+		t.Fatalf("current.temp = %v, want 30.5", fields["current.temp"])
+	}
+	if fields["current.conditions"] != "Clear" {
+		t.Fatalf("current.conditions = %v, want Clear", fields["current.conditions"])
+	}
+	if fields["daily[0].tempHigh"] != float64(32) {
+		t.Fatalf("daily[0].tempHigh = %v, want 32", fields["daily[0].tempHigh"])
+	}
+}
+
+func TestReJSONCache_Get_Miss(t *testing.T) {
+	ctx := context.Background()
+	cache := NewReJSONCache(newTestRedis(t))
+
+	_, hit, err := cache.Get(ctx, "weather:v2:nowhere")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if hit {
+		t.Fatal("expected cache miss")
+	}
+}
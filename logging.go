@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newLogger 建立一個輸出 JSON 的結構化 logger，取代原本的 log.Println，
+// 讓 city、cache_hit、upstream_status、duration_ms、trace_id 等欄位
+// 可以被日誌系統解析查詢。
+func newLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stdout, nil))
+}
+
+const traceIDHeader = "X-Trace-Id"
+const traceIDLocalsKey = "trace_id"
+
+// traceIDMiddleware 幫每個請求產生（或沿用客戶端帶來的）trace id，
+// 存進 fiber.Ctx 的 Locals 並回寫到回應標頭，供後續的 log 欄位與
+// OpenTelemetry span 共用。
+func traceIDMiddleware(c *fiber.Ctx) error {
+	traceID := c.Get(traceIDHeader)
+	if traceID == "" {
+		traceID = generateTraceID()
+	}
+	c.Locals(traceIDLocalsKey, traceID)
+	c.Set(traceIDHeader, traceID)
+	return c.Next()
+}
+
+func traceIDFromContext(c *fiber.Ctx) string {
+	if id, ok := c.Locals(traceIDLocalsKey).(string); ok {
+		return id
+	}
+	return ""
+}
+
+func generateTraceID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
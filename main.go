@@ -2,32 +2,89 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"context"
 
+	"github.com/Carlokb472/WeatherAPIRedis/ratelimit"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/gofiber/websocket/v2"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 )
 
 // 配置結構
 type Config struct {
-	WeatherAPIKey string
-	RedisHost     string
-	RedisPort     string
-	RedisPassword string
+	WeatherAPIKey      string
+	RedisHost          string
+	RedisPort          string
+	RedisPassword      string
+	CacheBackend       string
+	RefreshInterval    time.Duration
+	MaxSubscribers     int
+	UpstreamTimeout    time.Duration
+	UpstreamRetries    int
+	CBFailureThreshold int
+	CBOpenDuration     time.Duration
+	RateLimitPerMinute int
+	RateLimitPerDay    int
+	AdminSecret        string
+	WeatherProvider    string
+	OWMAPIKey          string
+	WeatherAPIComKey   string
+	NegativeCacheTTL   int
 }
 
 // 天氣服務結構
 type WeatherService struct {
 	config      Config
 	redisClient *redis.Client
+	cache       CacheBackend
+	logger      *slog.Logger
+	providers   map[string]Provider
+	chain       *ChainProvider
+}
+
+// secondsToDuration 把快取設定慣用的「秒數」轉成 time.Duration。
+func secondsToDuration(seconds int) time.Duration {
+	return time.Duration(seconds) * time.Second
+}
+
+// envDuration 讀取一個以秒為單位的環境變數，沒有設定或解析失敗時回傳預設值。
+func envDuration(key string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	seconds, err := strconv.Atoi(raw)
+	if err != nil {
+		return fallback
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// envInt 讀取一個整數型別的環境變數，沒有設定或解析失敗時回傳預設值。
+func envInt(key string, fallback int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return fallback
+	}
+	return value
 }
 
 func main() {
@@ -38,10 +95,24 @@ func main() {
 	}
 
 	config := Config{
-		WeatherAPIKey: os.Getenv("WEATHER_API_KEY"),
-		RedisHost:     os.Getenv("REDIS_HOST"),
-		RedisPort:     os.Getenv("REDIS_PORT"),
-		RedisPassword: os.Getenv("REDIS_PASSWORD"),
+		WeatherAPIKey:      os.Getenv("WEATHER_API_KEY"),
+		RedisHost:          os.Getenv("REDIS_HOST"),
+		RedisPort:          os.Getenv("REDIS_PORT"),
+		RedisPassword:      os.Getenv("REDIS_PASSWORD"),
+		CacheBackend:       os.Getenv("CACHE_BACKEND"),
+		RefreshInterval:    envDuration("REFRESH_INTERVAL", 5*time.Minute),
+		MaxSubscribers:     envInt("MAX_SUBSCRIBERS", 1000),
+		UpstreamTimeout:    envDuration("UPSTREAM_TIMEOUT", 10*time.Second),
+		UpstreamRetries:    envInt("UPSTREAM_RETRIES", 2),
+		CBFailureThreshold: envInt("CB_FAILURE_THRESHOLD", 5),
+		CBOpenDuration:     envDuration("CB_OPEN_DURATION", 30*time.Second),
+		RateLimitPerMinute: envInt("RATE_LIMIT_PER_MINUTE", 60),
+		RateLimitPerDay:    envInt("RATE_LIMIT_PER_DAY", 10000),
+		AdminSecret:        os.Getenv("ADMIN_SECRET"),
+		WeatherProvider:    os.Getenv("WEATHER_PROVIDER"),
+		OWMAPIKey:          os.Getenv("OWM_API_KEY"),
+		WeatherAPIComKey:   os.Getenv("WEATHERAPI_API_KEY"),
+		NegativeCacheTTL:   envInt("NEGATIVE_CACHE_TTL", 60),
 	}
 
 	// 初始化 Redis 客戶端
@@ -58,72 +129,275 @@ func main() {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
 
+	// 初始化 OpenTelemetry tracer provider
+	tracerProvider, err := initTracer()
+	if err != nil {
+		log.Fatalf("Failed to initialize tracer: %v", err)
+	}
+
+	logger := newLogger()
+
+	upstreamOpts := UpstreamClientOptions{
+		Timeout:             config.UpstreamTimeout,
+		Retries:             config.UpstreamRetries,
+		FailureThreshold:    config.CBFailureThreshold,
+		OpenDuration:        config.CBOpenDuration,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	visualCrossingUpstream := NewUpstreamClient(redisClient, "visualcrossing", upstreamOpts)
+
+	providers := map[string]Provider{
+		"visualcrossing": newVisualCrossingProvider(visualCrossingUpstream, config.WeatherAPIKey),
+		"openweathermap": newOpenWeatherMapProvider(NewUpstreamClient(redisClient, "openweathermap", upstreamOpts), config.OWMAPIKey),
+		"weatherapi":     newWeatherAPIProvider(NewUpstreamClient(redisClient, "weatherapi", upstreamOpts), config.WeatherAPIComKey),
+	}
+	chain := NewChainProvider(providers, providerOrder(config.WeatherProvider))
+
 	service := &WeatherService{
 		config:      config,
 		redisClient: redisClient,
+		cache:       NewCacheBackend(config.CacheBackend, redisClient),
+		logger:      logger,
+		providers:   providers,
+		chain:       chain,
 	}
 
+	// 啟動 pub/sub hub，背景定期重新整理被訂閱的城市
+	hub := NewPubSubHub(service, config.RefreshInterval, config.MaxSubscribers)
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	hub.Run(hubCtx)
+
+	// 初始化限流器
+	limiter := ratelimit.NewLimiter(redisClient, ratelimit.Limit{
+		PerMinute: config.RateLimitPerMinute,
+		PerDay:    config.RateLimitPerDay,
+	}, config.AdminSecret)
+
 	// 初始化 Fiber
 	app := fiber.New()
+	app.Use(traceIDMiddleware)
+	app.Use(limiter.Middleware())
 
 	// 定義路由
+	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
+	app.Post("/admin/keys", limiter.AdminRegisterKey)
 	app.Get("/weather/:city", service.getWeather)
+	app.Get("/weather/:city/stream", func(c *fiber.Ctx) error {
+		if websocket.IsWebSocketUpgrade(c) {
+			return websocket.New(hub.streamWebSocket)(c)
+		}
+		return hub.streamSSE(c)
+	})
+
+	// 優雅關閉：收到中斷訊號時依序關閉 Fiber、停止背景排程、斷開 Redis。
+	idleConnsClosed := make(chan struct{})
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+		<-sigCh
+
+		log.Println("Shutting down gracefully...")
+		if err := app.Shutdown(); err != nil {
+			log.Printf("Fiber shutdown error: %v", err)
+		}
+		stopHub()
+		hub.Shutdown()
+		if err := tracerProvider.Shutdown(context.Background()); err != nil {
+			log.Printf("Tracer shutdown error: %v", err)
+		}
+		if err := redisClient.Close(); err != nil {
+			log.Printf("Redis close error: %v", err)
+		}
+		close(idleConnsClosed)
+	}()
 
 	// 啟動服務器
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "3000"
 	}
-	log.Fatal(app.Listen(":" + port))
+	if err := app.Listen(":" + port); err != nil {
+		log.Printf("Listen error: %v", err)
+	}
+	<-idleConnsClosed
+}
+
+// providerOrder 依 preferred（通常來自 WEATHER_PROVIDER 或 ?provider=）
+// 決定 Chain 嘗試 provider 的順序，preferred 會被排到最前面。
+func providerOrder(preferred string) []string {
+	defaultOrder := []string{"visualcrossing", "openweathermap", "weatherapi"}
+	if preferred == "" {
+		return defaultOrder
+	}
+	order := []string{preferred}
+	for _, name := range defaultOrder {
+		if name != preferred {
+			order = append(order, name)
+		}
+	}
+	return order
+}
+
+// forecastCacheTTLSeconds 是標準化天氣資料在快取裡存活的時間。
+const forecastCacheTTLSeconds = 12 * 60 * 60
+
+// forecastCacheKey 回傳某城市標準化天氣資料的快取 key。明確指定
+// providerName 時（?provider=）會各自存在獨立的 key 底下，避免
+// 單次指定 provider 的查詢覆寫掉預設 Chain 之後要服務的結果。
+func forecastCacheKey(city, providerName string) string {
+	if providerName != "" {
+		return fmt.Sprintf("weather:v2:%s:%s", strings.ToLower(providerName), strings.ToLower(city))
+	}
+	return fmt.Sprintf("weather:v2:%s", strings.ToLower(city))
+}
+
+// forecastNotFoundKey 回傳某城市「查無此城市」負向快取的 key，
+// 與 forecastCacheKey 採用同樣的 per-provider 區隔規則。
+func forecastNotFoundKey(city, providerName string) string {
+	if providerName != "" {
+		return fmt.Sprintf("weather:v2:notfound:%s:%s", strings.ToLower(providerName), strings.ToLower(city))
+	}
+	return fmt.Sprintf("weather:v2:notfound:%s", strings.ToLower(city))
 }
 
 func (s *WeatherService) getWeather(c *fiber.Ctx) error {
+	start := time.Now()
 	city := c.Params("city")
-	cacheKey := fmt.Sprintf("weather:%s", strings.ToLower(city))
-	ctx := context.Background()
+	ctx := c.Context()
+	reqLogger := s.logger.With("city", city, "trace_id", traceIDFromContext(c))
 
-	// 檢查 Redis 快取
-	cachedData, err := s.redisClient.Get(ctx, cacheKey).Result()
-	if err == nil {
-		log.Println("Serving from cache")
-		var weatherData interface{}
-		json.Unmarshal([]byte(cachedData), &weatherData)
-		return c.JSON(weatherData)
+	var fields []string
+	if rawFields := c.Query("fields"); rawFields != "" {
+		fields = strings.Split(rawFields, ",")
 	}
-	if err != redis.Nil {
-		log.Printf("Redis error: %v", err)
+	raw := c.Query("raw") == "1"
+	providerName := c.Query("provider")
+
+	if raw {
+		return s.serveRawWeather(c, ctx, reqLogger, city, providerName)
 	}
 
-	// 調用 Visual Crossing API
-	url := fmt.Sprintf("https://weather.visualcrossing.com/VisualCrossingWebServices/rest/services/timeline/%s?key=%s", city, s.config.WeatherAPIKey)
-	resp, err := http.Get(url)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch weather data"})
+	cacheKey := forecastCacheKey(city, providerName)
+	notFoundKey := forecastNotFoundKey(city, providerName)
+
+	if _, notFound, _ := s.cache.Get(ctx, notFoundKey); notFound {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "city not found"})
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return c.Status(resp.StatusCode).JSON(fiber.Map{"error": "Invalid city or API error"})
+	if len(fields) > 0 {
+		projected, hit, err := s.cache.GetFields(ctx, cacheKey, fields)
+		if err != nil {
+			reqLogger.Warn("cache error", "error", err)
+		} else if hit {
+			reqLogger.Info("serving weather", "cache_hit", true, "duration_ms", time.Since(start).Milliseconds())
+			return c.JSON(projected)
+		}
+	} else {
+		cachedData, hit, err := s.cache.Get(ctx, cacheKey)
+		if err != nil {
+			reqLogger.Warn("cache error", "error", err)
+		} else if hit {
+			var forecast Forecast
+			json.Unmarshal([]byte(cachedData), &forecast)
+			reqLogger.Info("serving weather", "cache_hit", true, "duration_ms", time.Since(start).Milliseconds())
+			return c.JSON(forecast)
+		}
 	}
 
-	// 解析 API 響應
-	var weatherData interface{}
-	err = json.NewDecoder(resp.Body).Decode(&weatherData)
+	forecast, usedProvider, err := s.fetchForecast(ctx, city, providerName)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to parse weather data"})
+		if err == ErrCityNotFound {
+			if setErr := s.cache.Set(ctx, notFoundKey, "1", s.config.NegativeCacheTTL); setErr != nil {
+				reqLogger.Warn("failed to cache negative result", "error", setErr)
+			}
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "city not found"})
+		}
+		var circuitOpen *ErrCircuitOpen
+		if errors.As(err, &circuitOpen) {
+			c.Set("Retry-After", strconv.Itoa(int(circuitOpen.RetryAfter.Seconds())))
+			reqLogger.Error("upstream circuit open", "duration_ms", time.Since(start).Milliseconds())
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "upstream temporarily unavailable"})
+		}
+		reqLogger.Error("upstream error", "error", err, "duration_ms", time.Since(start).Milliseconds())
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := s.cache.Set(ctx, cacheKey, forecast, forecastCacheTTLSeconds); err != nil {
+		reqLogger.Warn("failed to cache weather data", "error", err)
+	}
+
+	reqLogger.Info("serving weather", "cache_hit", false, "provider", usedProvider, "duration_ms", time.Since(start).Milliseconds())
+	if len(fields) > 0 {
+		var asMap map[string]interface{}
+		if marshaled, err := json.Marshal(forecast); err == nil {
+			json.Unmarshal(marshaled, &asMap)
+			return c.JSON(projectFields(asMap, fields))
+		}
+	}
+	return c.JSON(forecast)
+}
+
+// serveRawWeather 處理 ?raw=1：略過標準化，直接回傳單一 provider 的
+// 原始回應，快取在各自獨立的 key 底下。
+func (s *WeatherService) serveRawWeather(c *fiber.Ctx, ctx context.Context, reqLogger *slog.Logger, city, providerName string) error {
+	if providerName == "" {
+		providerName = providerOrder(s.config.WeatherProvider)[0]
+	}
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("unknown provider %q", providerName)})
+	}
+
+	cacheKey := fmt.Sprintf("weather:raw:%s:%s", providerName, strings.ToLower(city))
+	if cachedData, hit, err := s.cache.Get(ctx, cacheKey); err == nil && hit {
+		var raw interface{}
+		json.Unmarshal([]byte(cachedData), &raw)
+		return c.JSON(raw)
 	}
 
-	// 存入 Redis，12 小時過期
-	weatherJSON, err := json.Marshal(weatherData)
+	body, err := provider.FetchRaw(ctx, city)
 	if err != nil {
-		log.Printf("Failed to marshal weather data: %v", err)
-	} else {
-		err = s.redisClient.SetEx(ctx, cacheKey, string(weatherJSON), time.Duration(12*60*60)*time.Second).Err()
+		if err == ErrCityNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "city not found"})
+		}
+		reqLogger.Error("raw upstream error", "provider", providerName, "error", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to parse weather data"})
+	}
+	if err := s.cache.Set(ctx, cacheKey, raw, 12*60*60); err != nil {
+		reqLogger.Warn("failed to cache raw weather data", "error", err)
+	}
+	return c.JSON(raw)
+}
+
+// fetchForecast 取得標準化後的天氣資料：若指定 providerName 則只用
+// 該 provider（不 failover）；否則依序嘗試 Chain 裡的所有 provider。
+// 背景重新整理排程（見 pubsub.go）也透過這個方法取得最新資料。
+func (s *WeatherService) fetchForecast(ctx context.Context, city, providerName string) (*Forecast, string, error) {
+	if providerName != "" {
+		provider, ok := s.providers[providerName]
+		if !ok {
+			return nil, "", fmt.Errorf("unknown provider %q", providerName)
+		}
+		raw, err := provider.FetchRaw(ctx, city)
 		if err != nil {
-			log.Printf("Failed to cache weather data: %v", err)
+			return nil, "", err
 		}
+		forecast, err := provider.Normalize(raw)
+		if err != nil {
+			return nil, "", err
+		}
+		return forecast, provider.Name(), nil
 	}
 
-	log.Println("Serving from API")
-	return c.JSON(weatherData)
+	forecast, usedProvider, err := s.chain.Fetch(ctx, city)
+	if err != nil {
+		return nil, "", err
+	}
+	return forecast, usedProvider, nil
 }
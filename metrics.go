@@ -0,0 +1,45 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus 指標：快取命中率、上游延遲/錯誤、Redis 指令延遲，
+// 透過 /metrics 暴露給 promhttp 抓取。
+var (
+	cacheHitsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "weatherapi_cache_hits_total",
+		Help: "Total number of cache hits, labelled by backend.",
+	}, []string{"backend"})
+
+	cacheMissesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "weatherapi_cache_misses_total",
+		Help: "Total number of cache misses, labelled by backend.",
+	}, []string{"backend"})
+
+	upstreamLatencySeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "weatherapi_upstream_latency_seconds",
+		Help:    "Latency of upstream weather provider calls.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"provider"})
+
+	upstreamErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "weatherapi_upstream_errors_total",
+		Help: "Total number of upstream errors, labelled by status code.",
+	}, []string{"provider", "status"})
+
+	redisLatencySeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "weatherapi_redis_latency_seconds",
+		Help:    "Latency of Redis commands issued by the weather service.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"command"})
+)
+
+// observeRedisLatency 記錄一次 Redis 指令的耗時，方便在 Get/Set/SetEx
+// 周圍直接量測。
+func observeRedisLatency(command string, start time.Time) {
+	redisLatencySeconds.WithLabelValues(command).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Coordinates 是標準化後天氣資料裡的經緯度。
+type Coordinates struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// CurrentConditions 是標準化後的「目前天氣」區塊。
+type CurrentConditions struct {
+	Temp       float64 `json:"temp"`
+	Humidity   float64 `json:"humidity"`
+	Conditions string  `json:"conditions"`
+}
+
+// HourlyForecast 是標準化後每小時預報的一個時間點。
+type HourlyForecast struct {
+	Time       string  `json:"time"`
+	Temp       float64 `json:"temp"`
+	Conditions string  `json:"conditions"`
+}
+
+// DailyForecast 是標準化後每日預報的一個時間點。
+type DailyForecast struct {
+	Date       string  `json:"date"`
+	TempHigh   float64 `json:"tempHigh"`
+	TempLow    float64 `json:"tempLow"`
+	Conditions string  `json:"conditions"`
+}
+
+// Forecast 是跨 provider 共用的標準化天氣資料格式；每個 Provider
+// 自己的 Normalize 負責把各家的原始回應轉成這個結構。
+type Forecast struct {
+	City    string            `json:"city"`
+	Coords  Coordinates       `json:"coords"`
+	Current CurrentConditions `json:"current"`
+	Hourly  []HourlyForecast  `json:"hourly"`
+	Daily   []DailyForecast   `json:"daily"`
+}
+
+// ErrCityNotFound 代表某個 provider 明確回報查無此城市，
+// 呼叫端可以據此寫入「查無此城市」的短期負向快取。
+var ErrCityNotFound = fmt.Errorf("city not found")
+
+// Provider 抽象一個天氣資料來源：取得原始回應，並能把它標準化成 Forecast。
+type Provider interface {
+	Name() string
+	FetchRaw(ctx context.Context, city string) ([]byte, error)
+	Normalize(raw []byte) (*Forecast, error)
+}
+
+// visualCrossingProvider 呼叫 Visual Crossing 的 timeline API。
+type visualCrossingProvider struct {
+	upstream *UpstreamClient
+	apiKey   string
+}
+
+func newVisualCrossingProvider(upstream *UpstreamClient, apiKey string) *visualCrossingProvider {
+	return &visualCrossingProvider{upstream: upstream, apiKey: apiKey}
+}
+
+func (p *visualCrossingProvider) Name() string { return "visualcrossing" }
+
+func (p *visualCrossingProvider) FetchRaw(ctx context.Context, city string) ([]byte, error) {
+	url := fmt.Sprintf("https://weather.visualcrossing.com/VisualCrossingWebServices/rest/services/timeline/%s?key=%s", city, p.apiKey)
+	body, err := p.upstream.Get(ctx, url)
+	if err != nil {
+		return nil, translateNotFound(err)
+	}
+	return body, nil
+}
+
+func (p *visualCrossingProvider) Normalize(raw []byte) (*Forecast, error) {
+	var vc struct {
+		Address           string  `json:"address"`
+		Latitude          float64 `json:"latitude"`
+		Longitude         float64 `json:"longitude"`
+		CurrentConditions struct {
+			Temp       float64 `json:"temp"`
+			Humidity   float64 `json:"humidity"`
+			Conditions string  `json:"conditions"`
+		} `json:"currentConditions"`
+		Days []struct {
+			Datetime   string  `json:"datetime"`
+			Tempmax    float64 `json:"tempmax"`
+			Tempmin    float64 `json:"tempmin"`
+			Conditions string  `json:"conditions"`
+			Hours      []struct {
+				Datetime   string  `json:"datetime"`
+				Temp       float64 `json:"temp"`
+				Conditions string  `json:"conditions"`
+			} `json:"hours"`
+		} `json:"days"`
+	}
+	if err := json.Unmarshal(raw, &vc); err != nil {
+		return nil, err
+	}
+
+	forecast := &Forecast{
+		City:   vc.Address,
+		Coords: Coordinates{Lat: vc.Latitude, Lon: vc.Longitude},
+		Current: CurrentConditions{
+			Temp:       vc.CurrentConditions.Temp,
+			Humidity:   vc.CurrentConditions.Humidity,
+			Conditions: vc.CurrentConditions.Conditions,
+		},
+	}
+	for _, day := range vc.Days {
+		forecast.Daily = append(forecast.Daily, DailyForecast{
+			Date:       day.Datetime,
+			TempHigh:   day.Tempmax,
+			TempLow:    day.Tempmin,
+			Conditions: day.Conditions,
+		})
+		for _, hour := range day.Hours {
+			forecast.Hourly = append(forecast.Hourly, HourlyForecast{
+				Time:       fmt.Sprintf("%sT%s", day.Datetime, hour.Datetime),
+				Temp:       hour.Temp,
+				Conditions: hour.Conditions,
+			})
+		}
+	}
+	return forecast, nil
+}
+
+// openWeatherMapProvider 呼叫 OpenWeatherMap 的 One Call API。
+type openWeatherMapProvider struct {
+	upstream *UpstreamClient
+	apiKey   string
+}
+
+func newOpenWeatherMapProvider(upstream *UpstreamClient, apiKey string) *openWeatherMapProvider {
+	return &openWeatherMapProvider{upstream: upstream, apiKey: apiKey}
+}
+
+func (p *openWeatherMapProvider) Name() string { return "openweathermap" }
+
+func (p *openWeatherMapProvider) FetchRaw(ctx context.Context, city string) ([]byte, error) {
+	url := fmt.Sprintf("https://api.openweathermap.org/data/2.5/weather?q=%s&appid=%s&units=metric", city, p.apiKey)
+	body, err := p.upstream.Get(ctx, url)
+	if err != nil {
+		return nil, translateNotFound(err)
+	}
+	return body, nil
+}
+
+func (p *openWeatherMapProvider) Normalize(raw []byte) (*Forecast, error) {
+	var owm struct {
+		Name  string `json:"name"`
+		Coord struct {
+			Lat float64 `json:"lat"`
+			Lon float64 `json:"lon"`
+		} `json:"coord"`
+		Main struct {
+			Temp     float64 `json:"temp"`
+			Humidity float64 `json:"humidity"`
+		} `json:"main"`
+		Weather []struct {
+			Main string `json:"main"`
+		} `json:"weather"`
+	}
+	if err := json.Unmarshal(raw, &owm); err != nil {
+		return nil, err
+	}
+
+	conditions := ""
+	if len(owm.Weather) > 0 {
+		conditions = owm.Weather[0].Main
+	}
+
+	return &Forecast{
+		City:   owm.Name,
+		Coords: Coordinates{Lat: owm.Coord.Lat, Lon: owm.Coord.Lon},
+		Current: CurrentConditions{
+			Temp:       owm.Main.Temp,
+			Humidity:   owm.Main.Humidity,
+			Conditions: conditions,
+		},
+	}, nil
+}
+
+// weatherAPIProvider 呼叫 WeatherAPI.com 的 forecast API。
+type weatherAPIProvider struct {
+	upstream *UpstreamClient
+	apiKey   string
+}
+
+func newWeatherAPIProvider(upstream *UpstreamClient, apiKey string) *weatherAPIProvider {
+	return &weatherAPIProvider{upstream: upstream, apiKey: apiKey}
+}
+
+func (p *weatherAPIProvider) Name() string { return "weatherapi" }
+
+func (p *weatherAPIProvider) FetchRaw(ctx context.Context, city string) ([]byte, error) {
+	url := fmt.Sprintf("https://api.weatherapi.com/v1/forecast.json?key=%s&q=%s&days=3", p.apiKey, city)
+	body, err := p.upstream.Get(ctx, url)
+	if err != nil {
+		return nil, translateNotFound(err)
+	}
+	return body, nil
+}
+
+func (p *weatherAPIProvider) Normalize(raw []byte) (*Forecast, error) {
+	var wa struct {
+		Location struct {
+			Name string  `json:"name"`
+			Lat  float64 `json:"lat"`
+			Lon  float64 `json:"lon"`
+		} `json:"location"`
+		Current struct {
+			TempC     float64 `json:"temp_c"`
+			Humidity  float64 `json:"humidity"`
+			Condition struct {
+				Text string `json:"text"`
+			} `json:"condition"`
+		} `json:"current"`
+		Forecast struct {
+			Forecastday []struct {
+				Date string `json:"date"`
+				Day  struct {
+					MaxtempC  float64 `json:"maxtemp_c"`
+					MintempC  float64 `json:"mintemp_c"`
+					Condition struct {
+						Text string `json:"text"`
+					} `json:"condition"`
+				} `json:"day"`
+			} `json:"forecastday"`
+		} `json:"forecast"`
+	}
+	if err := json.Unmarshal(raw, &wa); err != nil {
+		return nil, err
+	}
+
+	forecast := &Forecast{
+		City:   wa.Location.Name,
+		Coords: Coordinates{Lat: wa.Location.Lat, Lon: wa.Location.Lon},
+		Current: CurrentConditions{
+			Temp:       wa.Current.TempC,
+			Humidity:   wa.Current.Humidity,
+			Conditions: wa.Current.Condition.Text,
+		},
+	}
+	for _, day := range wa.Forecast.Forecastday {
+		forecast.Daily = append(forecast.Daily, DailyForecast{
+			Date:       day.Date,
+			TempHigh:   day.Day.MaxtempC,
+			TempLow:    day.Day.MintempC,
+			Conditions: day.Day.Condition.Text,
+		})
+	}
+	return forecast, nil
+}
+
+// translateNotFound 把斷路器開啟以外的 4xx 錯誤視為「查無此城市」，
+// 讓 Chain 可以決定要不要寫入負向快取，而不是無腦重試下一個 provider。
+func translateNotFound(err error) error {
+	if strings.Contains(err.Error(), "status 404") {
+		return ErrCityNotFound
+	}
+	return err
+}
+
+// ChainProvider 依序嘗試一組 Provider，遇到錯誤或逾時就換下一個，
+// 並回傳第一個成功的標準化結果。
+type ChainProvider struct {
+	providers []Provider
+}
+
+// NewChainProvider 依 order 指定的名稱，從 available 中挑出對應的 Provider
+// 並依序排列；找不到名稱的項目會被忽略。
+func NewChainProvider(available map[string]Provider, order []string) *ChainProvider {
+	providers := make([]Provider, 0, len(order))
+	for _, name := range order {
+		if p, ok := available[name]; ok {
+			providers = append(providers, p)
+		}
+	}
+	return &ChainProvider{providers: providers}
+}
+
+// Fetch 依序嘗試 chain 裡的 provider，回傳第一個成功標準化的 Forecast
+// 與實際提供資料的 Provider 名稱。
+func (c *ChainProvider) Fetch(ctx context.Context, city string) (*Forecast, string, error) {
+	var lastErr error
+	for _, p := range c.providers {
+		raw, err := p.FetchRaw(ctx, city)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		forecast, err := p.Normalize(raw)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return forecast, p.Name(), nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no weather providers configured")
+	}
+	return nil, "", lastErr
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+// updateChannel 回傳某個城市的 pub/sub 頻道名稱。
+func updateChannel(city string) string {
+	return fmt.Sprintf("weather:updates:%s", strings.ToLower(city))
+}
+
+// PubSubHub 管理「某城市快取即將過期時重新抓取並廣播」的背景流程，
+// 以及把 Redis pub/sub 訊息轉發給 WebSocket/SSE 連線的訂閱者。
+type PubSubHub struct {
+	service           *WeatherService
+	refreshInterval   time.Duration
+	maxSubscribers    int
+	mu                sync.Mutex
+	watched           map[string]int
+	activeSubscribers int
+	shutdown          chan struct{}
+	wg                sync.WaitGroup
+}
+
+// NewPubSubHub 建立 hub，refreshInterval 與 maxSubscribers 來自環境變數。
+func NewPubSubHub(service *WeatherService, refreshInterval time.Duration, maxSubscribers int) *PubSubHub {
+	return &PubSubHub{
+		service:         service,
+		refreshInterval: refreshInterval,
+		maxSubscribers:  maxSubscribers,
+		watched:         make(map[string]int),
+		shutdown:        make(chan struct{}),
+	}
+}
+
+// Watch 替城市的訂閱者計數加一，之後只要還有人訂閱就會持續收到更新。
+func (h *PubSubHub) Watch(city string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.watched[strings.ToLower(city)]++
+}
+
+// Unwatch 替城市的訂閱者計數減一；歸零時把城市從背景重新整理清單
+// 移除，避免對已經沒人訂閱的城市繼續消耗上游配額。
+func (h *PubSubHub) Unwatch(city string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	city = strings.ToLower(city)
+	if n, ok := h.watched[city]; ok {
+		if n <= 1 {
+			delete(h.watched, city)
+		} else {
+			h.watched[city] = n - 1
+		}
+	}
+}
+
+// Run 啟動背景重新整理排程，會在 refreshInterval 附近加上抖動(jitter)，
+// 避免所有城市同時打上游 API。
+func (h *PubSubHub) Run(ctx context.Context) {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		for {
+			jitter := time.Duration(rand.Int63n(int64(h.refreshInterval)/2 + 1))
+			select {
+			case <-time.After(h.refreshInterval + jitter):
+				h.refreshWatchedCities(ctx)
+			case <-h.shutdown:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (h *PubSubHub) refreshWatchedCities(ctx context.Context) {
+	h.mu.Lock()
+	cities := make([]string, 0, len(h.watched))
+	for city := range h.watched {
+		cities = append(cities, city)
+	}
+	h.mu.Unlock()
+
+	for _, city := range cities {
+		forecast, _, err := h.service.fetchForecast(ctx, city, "")
+		if err != nil {
+			h.service.logger.Warn("pubsub refresh failed", "city", city, "error", err)
+			continue
+		}
+		payload, err := json.Marshal(forecast)
+		if err != nil {
+			h.service.logger.Warn("pubsub marshal failed", "city", city, "error", err)
+			continue
+		}
+		// 把重新整理後的結果寫回快取，這樣下一個 plain GET /weather/:city
+		// 才不會在背景才剛抓過之後又算成 cache miss 再打一次上游。背景重新整理
+		// 一律走沒有指定 provider 的預設 Chain，所以用同一把沒有 provider 後綴的 key。
+		if err := h.service.cache.Set(ctx, forecastCacheKey(city, ""), forecast, forecastCacheTTLSeconds); err != nil {
+			h.service.logger.Warn("pubsub cache update failed", "city", city, "error", err)
+		}
+		if err := h.service.redisClient.Publish(ctx, updateChannel(city), payload).Err(); err != nil {
+			h.service.logger.Warn("pubsub publish failed", "city", city, "error", err)
+		}
+	}
+}
+
+// acquireSlot 套用 maxSubscribers 上限，超過時拒絕新的訂閱連線。
+func (h *PubSubHub) acquireSlot() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.activeSubscribers >= h.maxSubscribers {
+		return false
+	}
+	h.activeSubscribers++
+	return true
+}
+
+func (h *PubSubHub) releaseSlot() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.activeSubscribers--
+}
+
+// Shutdown 停止背景排程並等待它結束；個別連線的關閉由各自的
+// context 取消負責，這裡只負責背景 goroutine。
+func (h *PubSubHub) Shutdown() {
+	close(h.shutdown)
+	h.wg.Wait()
+}
+
+// streamWebSocket 處理 /weather/:city/stream 的 WebSocket 升級連線，
+// 將該城市的 pub/sub 訊息逐筆轉發給客戶端。
+func (h *PubSubHub) streamWebSocket(conn *websocket.Conn) {
+	city := conn.Params("city")
+	if !h.acquireSlot() {
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"error":"too many subscribers"}`))
+		conn.Close()
+		return
+	}
+	defer h.releaseSlot()
+
+	h.Watch(city)
+	defer h.Unwatch(city)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub := h.service.redisClient.Subscribe(ctx, updateChannel(city))
+	defer sub.Close()
+
+	// 偵測客戶端斷線，讓我們可以結束轉發迴圈。
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(msg.Payload)); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// streamSSE 處理 /weather/:city/stream 的 Server-Sent Events 模式，
+// 當請求帶有 "Accept: text/event-stream" 時使用。
+func (h *PubSubHub) streamSSE(c *fiber.Ctx) error {
+	city := c.Params("city")
+	if !h.acquireSlot() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "too many subscribers"})
+	}
+
+	h.Watch(city)
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+
+	ctx, cancel := context.WithCancel(c.Context())
+	sub := h.service.redisClient.Subscribe(ctx, updateChannel(city))
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer h.releaseSlot()
+		defer h.Unwatch(city)
+		defer cancel()
+		defer sub.Close()
+
+		ch := sub.Channel()
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				if _, err := fmt.Fprintf(w, "data: %s\n\n", msg.Payload); err != nil {
+					return
+				}
+				if err := w.Flush(); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,173 @@
+// Package ratelimit 提供以 Redis INCR/EXPIRE 實作的每分鐘與每日
+// 配額限制，並支援透過 API key 自訂限制。
+package ratelimit
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Limit 描述一組每分鐘／每日的請求上限。
+type Limit struct {
+	PerMinute int
+	PerDay    int
+}
+
+// Limiter 是一個以 Redis 為後端的滑動視窗限流器，預設依來源 IP
+// 限流，若請求帶有 X-API-Key 則改用該 key 的配額。
+type Limiter struct {
+	redisClient  *redis.Client
+	defaultLimit Limit
+	adminSecret  string
+}
+
+// NewLimiter 建立 Limiter，defaultLimit 套用在沒有註冊過的 API key
+// 以及純 IP 請求上；adminSecret 用來保護 /admin/keys。
+func NewLimiter(redisClient *redis.Client, defaultLimit Limit, adminSecret string) *Limiter {
+	return &Limiter{
+		redisClient:  redisClient,
+		defaultLimit: defaultLimit,
+		adminSecret:  adminSecret,
+	}
+}
+
+func apiKeyHashKey(apiKey string) string {
+	return fmt.Sprintf("apikeys:%s", apiKey)
+}
+
+func minuteWindowKey(identity string, window time.Time) string {
+	return fmt.Sprintf("rl:%s:%d", identity, window.Unix()/60)
+}
+
+func dailyQuotaKey(apiKey string, day time.Time) string {
+	return fmt.Sprintf("quota:%s:%s", apiKey, day.Format("20060102"))
+}
+
+// limitFor 查出某個 API key 的自訂限制，找不到時回傳預設值。
+func (l *Limiter) limitFor(ctx context.Context, apiKey string) Limit {
+	if apiKey == "" {
+		return l.defaultLimit
+	}
+	vals, err := l.redisClient.HMGet(ctx, apiKeyHashKey(apiKey), "per_minute", "per_day").Result()
+	if err != nil || vals[0] == nil {
+		return l.defaultLimit
+	}
+	limit := l.defaultLimit
+	if perMinute, ok := vals[0].(string); ok {
+		if n, err := strconv.Atoi(perMinute); err == nil {
+			limit.PerMinute = n
+		}
+	}
+	if len(vals) > 1 {
+		if perDay, ok := vals[1].(string); ok {
+			if n, err := strconv.Atoi(perDay); err == nil {
+				limit.PerDay = n
+			}
+		}
+	}
+	return limit
+}
+
+// Middleware 回傳一個 Fiber 中介層，依 IP 或 X-API-Key 執行每分鐘
+// 滑動視窗限流，若帶有 API key 也會同時檢查每日配額。
+func (l *Limiter) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.Context()
+
+		apiKey := c.Get("X-API-Key")
+		identity := apiKey
+		if identity == "" {
+			identity = c.IP()
+		}
+
+		limit := l.limitFor(ctx, apiKey)
+		now := time.Now()
+
+		minuteKey := minuteWindowKey(identity, now)
+		count, err := l.redisClient.Incr(ctx, minuteKey).Result()
+		if err != nil {
+			return c.Next() // Redis 不可用時放行，避免限流器本身造成全站中斷
+		}
+		// 每次都呼叫 ExpireNX 而不是只在 count == 1 時設定：若進程在 Incr
+		// 之後、Expire 之前被殺掉，key 會卡在沒有 TTL 的狀態並無限累加，
+		// 等於永久鎖死那個視窗。ExpireNX 只在 key 還沒有 TTL 時才生效，
+		// 所以重複呼叫是安全的。
+		l.redisClient.ExpireNX(ctx, minuteKey, time.Minute)
+
+		remaining := limit.PerMinute - int(count)
+		resetSeconds := 60 - now.Second()
+		c.Set("X-RateLimit-Limit", strconv.Itoa(limit.PerMinute))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(max(0, remaining)))
+		c.Set("X-RateLimit-Reset", strconv.Itoa(resetSeconds))
+
+		if int(count) > limit.PerMinute {
+			c.Set("Retry-After", strconv.Itoa(resetSeconds))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "rate limit exceeded"})
+		}
+
+		if apiKey != "" {
+			dayKey := dailyQuotaKey(apiKey, now)
+			dayCount, err := l.redisClient.Incr(ctx, dayKey).Result()
+			if err == nil {
+				l.redisClient.ExpireNX(ctx, dayKey, 24*time.Hour)
+				if int(dayCount) > limit.PerDay {
+					c.Set("Retry-After", strconv.Itoa(int(time.Until(endOfDay(now)).Seconds())))
+					return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "daily quota exceeded"})
+				}
+			}
+		}
+
+		return c.Next()
+	}
+}
+
+func endOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 23, 59, 59, 0, t.Location())
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// registerKeyRequest 是 POST /admin/keys 的請求主體。
+type registerKeyRequest struct {
+	APIKey    string `json:"api_key"`
+	PerMinute int    `json:"per_minute"`
+	PerDay    int    `json:"per_day"`
+}
+
+// AdminRegisterKey 處理 POST /admin/keys：以共享密鑰驗證後，把
+// API key 的自訂限制寫進 Redis hash，供 limitFor 查詢。
+func (l *Limiter) AdminRegisterKey(c *fiber.Ctx) error {
+	if l.adminSecret == "" || subtle.ConstantTimeCompare([]byte(c.Get("X-Admin-Secret")), []byte(l.adminSecret)) != 1 {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	var req registerKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	if req.APIKey == "" || req.PerMinute <= 0 || req.PerDay <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "api_key, per_minute and per_day are required"})
+	}
+
+	err := l.redisClient.HSet(c.Context(), apiKeyHashKey(req.APIKey),
+		"per_minute", req.PerMinute,
+		"per_day", req.PerDay,
+	).Err()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to register API key"})
+	}
+
+	return c.JSON(fiber.Map{"status": "registered", "api_key": req.APIKey})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	circuitBreakerKeyPrefix = "weather:cb:"
+)
+
+// UpstreamClient 包裝對 Visual Crossing 的呼叫，加上連線池、重試與
+// 跨多個應用實例共享狀態的斷路器(circuit breaker)。
+type UpstreamClient struct {
+	httpClient       *http.Client
+	redisClient      *redis.Client
+	breakerName      string
+	retries          int
+	failureThreshold int
+	openDuration     time.Duration
+}
+
+// UpstreamClientOptions 集中所有可由環境變數調整的行為。
+type UpstreamClientOptions struct {
+	Timeout             time.Duration
+	Retries             int
+	FailureThreshold    int
+	OpenDuration        time.Duration
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+}
+
+// NewUpstreamClient 建立一個對 Visual Crossing 專用的 UpstreamClient，
+// breakerName 用來區分未來可能新增的其他上游服務各自的斷路器狀態。
+func NewUpstreamClient(redisClient *redis.Client, breakerName string, opts UpstreamClientOptions) *UpstreamClient {
+	transport := &http.Transport{
+		MaxIdleConnsPerHost: opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:     opts.IdleConnTimeout,
+	}
+	return &UpstreamClient{
+		httpClient: &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: transport,
+		},
+		redisClient:      redisClient,
+		breakerName:      breakerName,
+		retries:          opts.Retries,
+		failureThreshold: opts.FailureThreshold,
+		openDuration:     opts.OpenDuration,
+	}
+}
+
+// ErrCircuitOpen 代表斷路器目前處於開啟狀態，呼叫端應該直接回應
+// 上游暫時不可用，而不要再嘗試呼叫。
+type ErrCircuitOpen struct {
+	RetryAfter time.Duration
+}
+
+func (e *ErrCircuitOpen) Error() string {
+	return fmt.Sprintf("circuit breaker open, retry after %s", e.RetryAfter)
+}
+
+// Get 對 url 發出 GET 請求，途中套用斷路器檢查與指數退避重試。
+// 斷路器狀態存在 Redis，讓多個應用實例共享同一份判斷。
+func (u *UpstreamClient) Get(ctx context.Context, url string) ([]byte, error) {
+	if open, retryAfter := u.breakerOpen(ctx); open {
+		return nil, &ErrCircuitOpen{RetryAfter: retryAfter}
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= u.retries; attempt++ {
+		if attempt > 0 {
+			backoff := u.backoffDuration(attempt)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		body, status, err := u.doRequest(ctx, url)
+		if err == nil && status == http.StatusOK {
+			u.recordSuccess(ctx)
+			return body, nil
+		}
+
+		if err != nil {
+			lastErr = err
+		} else {
+			lastErr = fmt.Errorf("upstream returned status %d", status)
+		}
+
+		// 只有 5xx / 429 才值得重試；其餘錯誤（例如 404 找不到城市）直接回傳。
+		// 這種情況代表上游本身是健康的，只是這次查詢沒有結果，所以不計入
+		// 斷路器的失敗次數，以免使用者查詢不存在的城市連帶拖垮其他城市的請求。
+		if err == nil && !isRetryableStatus(status) {
+			return body, lastErr
+		}
+	}
+
+	u.recordFailure(ctx)
+	return nil, lastErr
+}
+
+func (u *UpstreamClient) doRequest(ctx context.Context, url string) ([]byte, int, error) {
+	ctx, span := startSpan(ctx, "http.Get")
+	defer span.End()
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := u.httpClient.Do(req)
+	upstreamLatencySeconds.WithLabelValues(u.breakerName).Observe(time.Since(start).Seconds())
+	if err != nil {
+		upstreamErrorsTotal.WithLabelValues(u.breakerName, "error").Inc()
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if !isSuccessStatus(resp.StatusCode) {
+		upstreamErrorsTotal.WithLabelValues(u.breakerName, fmt.Sprintf("%d", resp.StatusCode)).Inc()
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	return body, resp.StatusCode, nil
+}
+
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func isSuccessStatus(status int) bool {
+	return status == http.StatusOK
+}
+
+// backoffDuration 是帶有抖動(jitter)的指數退避：base * 2^(attempt-1) 再加上隨機值。
+func (u *UpstreamClient) backoffDuration(attempt int) time.Duration {
+	base := 100 * time.Millisecond
+	backoff := base * time.Duration(1<<uint(attempt-1))
+	jitter := time.Duration(rand.Int63n(int64(base)))
+	return backoff + jitter
+}
+
+func (u *UpstreamClient) openKey() string {
+	return circuitBreakerKeyPrefix + u.breakerName + ":open"
+}
+
+func (u *UpstreamClient) failuresKey() string {
+	return circuitBreakerKeyPrefix + u.breakerName + ":failures"
+}
+
+// breakerOpen 檢查斷路器是否開啟；開啟時回傳剩餘的開啟時間供
+// Retry-After 使用。
+func (u *UpstreamClient) breakerOpen(ctx context.Context) (bool, time.Duration) {
+	ttl, err := u.redisClient.TTL(ctx, u.openKey()).Result()
+	if err != nil || ttl <= 0 {
+		return false, 0
+	}
+	return true, ttl
+}
+
+func (u *UpstreamClient) recordSuccess(ctx context.Context) {
+	u.redisClient.Del(ctx, u.failuresKey())
+}
+
+// recordFailure 累計失敗次數，達到門檻就開啟斷路器並重置計數，
+// 讓它在 openDuration 之後自動進入 half-open（允許下一次請求重新嘗試）。
+func (u *UpstreamClient) recordFailure(ctx context.Context) {
+	failures, err := u.redisClient.Incr(ctx, u.failuresKey()).Result()
+	if err != nil {
+		return
+	}
+	if failures == 1 {
+		u.redisClient.Expire(ctx, u.failuresKey(), u.openDuration)
+	}
+	if int(failures) >= u.failureThreshold {
+		u.redisClient.Set(ctx, u.openKey(), "1", u.openDuration)
+		u.redisClient.Del(ctx, u.failuresKey())
+	}
+}